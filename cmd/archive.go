@@ -0,0 +1,447 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/roryq/wrench/pkg/spanner"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputFilesystem = "fs"
+	outputTar        = "tar"
+	outputZip        = "zip"
+	stdoutPath       = "-"
+
+	manifestFilename = "manifest.json"
+
+	defaultTarArchiveName = "schema.tar.gz"
+	defaultZipArchiveName = "schema.zip"
+)
+
+// buildVersion is recorded in the archive manifest. It mirrors the version
+// reported by `wrench version` and is overridden at build time via -ldflags.
+var buildVersion = "dev"
+
+// databaseName resolves the database identifier recorded in the archive manifest
+// from the same environment variables newSpannerClient uses to build the DSN.
+func databaseName(c *cobra.Command) string {
+	return fmt.Sprintf("projects/%s/instances/%s/databases/%s",
+		os.Getenv("SPANNER_PROJECT_ID"), os.Getenv("SPANNER_INSTANCE_ID"), os.Getenv("SPANNER_DATABASE_ID"))
+}
+
+// archivePathFor resolves the file (or "-" for stdout) that a tar/zip
+// archive should be written to: the explicit --archive-path if given,
+// otherwise a sensible default name for the format. It is irrelevant for
+// format fs, where files are written directly under --directory.
+func archivePathFor(c *cobra.Command, format string) (string, error) {
+	archivePath, err := c.Flags().GetString(flagNameArchivePath)
+	if err != nil {
+		return "", err
+	}
+	if archivePath != "" {
+		return archivePath, nil
+	}
+	switch format {
+	case outputTar:
+		return defaultTarArchiveName, nil
+	case outputZip:
+		return defaultZipArchiveName, nil
+	default:
+		return "", nil
+	}
+}
+
+// resolveOutput normalizes the --output flag value into the archive format
+// newArchiveWriter understands and the destination it should write to.
+// "--output=-" is shorthand for "stream a tar archive to stdout", so it
+// resolves to (outputTar, stdoutPath) regardless of --archive-path; any
+// other format resolves its destination via archivePathFor as usual.
+func resolveOutput(c *cobra.Command, format string) (resolvedFormat, archivePath string, err error) {
+	if format == stdoutPath {
+		return outputTar, stdoutPath, nil
+	}
+	archivePath, err = archivePathFor(c, format)
+	if err != nil {
+		return "", "", err
+	}
+	return format, archivePath, nil
+}
+
+// archiveManifest is written as manifest.json at the root of every archive produced
+// by loadDiscrete so downstream tools can verify the archive's contents without
+// extracting it.
+type archiveManifest struct {
+	Database  string            `json:"database"`
+	Timestamp time.Time         `json:"timestamp"`
+	Version   string            `json:"wrench_version"`
+	Files     map[string]string `json:"files"` // relative path -> SHA256 hex digest
+}
+
+// archiveWriter abstracts writing the table/index/static_data tree either to the
+// filesystem (the pre-existing behaviour) or into a tar.gz/zip archive.
+type archiveWriter interface {
+	// WriteFile adds a file at the given path (relative to the archive/schema root)
+	// with the given content.
+	WriteFile(relPath string, content []byte) error
+	// Close finalizes the archive, writing the manifest first.
+	Close(database, version string) error
+}
+
+// newArchiveWriter builds the archiveWriter for format. fsRoot is the
+// directory files are written under for format fs (the existing
+// --directory behaviour); archivePath is the destination file (or "-" for
+// stdout) for format tar/zip, resolved separately via archivePathFor so
+// --output never has to double as a filesystem path.
+func newArchiveWriter(format, fsRoot, archivePath string) (archiveWriter, func() error, error) {
+	switch format {
+	case "", outputFilesystem:
+		return &fsArchiveWriter{root: fsRoot}, func() error { return nil }, nil
+	case outputTar:
+		w, closeFn, err := openArchiveDestination(archivePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		return &tarArchiveWriter{tw: tw, gz: gz}, closeFn, nil
+	case outputZip:
+		w, closeFn, err := openArchiveDestination(archivePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, closeFn, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported --output format %q", format)
+	}
+}
+
+func openArchiveDestination(archivePath string) (io.Writer, func() error, error) {
+	if archivePath == stdoutPath {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// fsArchiveWriter preserves the original behaviour: files are written directly
+// under the schema directory.
+type fsArchiveWriter struct {
+	root string
+}
+
+func (w *fsArchiveWriter) WriteFile(relPath string, content []byte) error {
+	full := filepath.Join(w.root, relPath)
+	if err := mkdir(filepath.Dir(full)); err != nil {
+		return err
+	}
+	return os.WriteFile(full, content, fileModeFor(relPath))
+}
+
+// fileModeFor mirrors the permissions the pre-archive writeDDL/writeData
+// helpers used: 0644 for static data, 0664 for everything else.
+func fileModeFor(relPath string) os.FileMode {
+	objectType := strings.SplitN(relPath, string(filepath.Separator), 2)[0]
+	if objectType == dirStaticData {
+		return 0644
+	}
+	return 0664
+}
+
+func (w *fsArchiveWriter) Close(database, version string) error {
+	return nil
+}
+
+type tarArchiveWriter struct {
+	tw    *tar.Writer
+	gz    *gzip.Writer
+	files map[string]string
+}
+
+func (w *tarArchiveWriter) WriteFile(relPath string, content []byte) error {
+	if w.files == nil {
+		w.files = map[string]string{}
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name: relPath,
+		Mode: int64(fileModeFor(relPath)),
+		Size: int64(len(content)),
+	}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(content); err != nil {
+		return err
+	}
+	w.files[relPath] = sha256Hex(content)
+	return nil
+}
+
+func (w *tarArchiveWriter) Close(database, version string) error {
+	manifest, err := marshalManifest(database, version, w.files)
+	if err != nil {
+		return err
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name: manifestFilename,
+		Mode: 0664,
+		Size: int64(len(manifest)),
+	}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(manifest); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+type zipArchiveWriter struct {
+	zw    *zip.Writer
+	files map[string]string
+}
+
+func (w *zipArchiveWriter) WriteFile(relPath string, content []byte) error {
+	if w.files == nil {
+		w.files = map[string]string{}
+	}
+	f, err := w.zw.Create(relPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		return err
+	}
+	w.files[relPath] = sha256Hex(content)
+	return nil
+}
+
+func (w *zipArchiveWriter) Close(database, version string) error {
+	manifest, err := marshalManifest(database, version, w.files)
+	if err != nil {
+		return err
+	}
+	f, err := w.zw.Create(manifestFilename)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(manifest); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+func marshalManifest(database, version string, files map[string]string) ([]byte, error) {
+	return json.MarshalIndent(archiveManifest{
+		Database:  database,
+		Timestamp: time.Now(),
+		Version:   version,
+		Files:     files,
+	}, "", "  ")
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// knownArchiveObjectTypes are the top-level directories a load-discrete
+// archive may contain; applyFromArchive rejects any entry outside these so a
+// malformed or hand-crafted archive can't smuggle arbitrary bytes into
+// client.ApplyDDL.
+var knownArchiveObjectTypes = map[string]bool{
+	dirTable:      true,
+	dirIndex:      true,
+	dirStaticData: true,
+}
+
+// archiveEntry is one file extracted from a load-discrete archive, keyed by
+// its path relative to the archive root (e.g. "table/users.sql" or
+// "static_data/roles.sql").
+type archiveEntry struct {
+	relPath string
+	content []byte
+}
+
+// applyFromArchive is the inverse of an archived loadDiscrete: it extracts a
+// tar.gz or zip produced by `load-discrete --output=tar|zip`, then applies
+// the DDL and static data statements it contains against client.
+func applyFromArchive(ctx context.Context, client *spanner.Client, archivePath string) error {
+	var (
+		entries []archiveEntry
+		err     error
+	)
+	switch {
+	case hasArchiveSuffix(archivePath, ".tar.gz", ".tgz"):
+		entries, err = readTarEntries(archivePath)
+	case hasArchiveSuffix(archivePath, ".zip"):
+		entries, err = readZipEntries(archivePath)
+	default:
+		return fmt.Errorf("unrecognized archive extension for %q, expected .tar.gz or .zip", archivePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	ddlStatements, staticDataStatements, err := classifyArchiveEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	if len(ddlStatements) > 0 {
+		if err := client.ApplyDDL(ctx, []byte(strings.Join(ddlStatements, ";\n"))); err != nil {
+			return err
+		}
+	}
+	if len(staticDataStatements) > 0 {
+		if err := client.ApplyStaticData(ctx, staticDataStatements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classifyArchiveEntries splits entries into DDL and static-data statement
+// bodies by their top-level directory, rejecting any entry whose top-level
+// directory isn't one of knownArchiveObjectTypes before it can be joined
+// into a statement executed against a live database.
+func classifyArchiveEntries(entries []archiveEntry) (ddlStatements, staticDataStatements []string, err error) {
+	for _, e := range entries {
+		objectType := strings.SplitN(e.relPath, string(filepath.Separator), 2)[0]
+		if !knownArchiveObjectTypes[objectType] {
+			return nil, nil, fmt.Errorf("archive entry %q has an unrecognized object type %q, expected one of table, index, static_data", e.relPath, objectType)
+		}
+		if objectType == dirStaticData {
+			staticDataStatements = append(staticDataStatements, string(e.content))
+		} else {
+			ddlStatements = append(ddlStatements, string(e.content))
+		}
+	}
+	return ddlStatements, staticDataStatements, nil
+}
+
+func hasArchiveSuffix(path string, suffixes ...string) bool {
+	for _, s := range suffixes {
+		if len(path) >= len(s) && path[len(path)-len(s):] == s {
+			return true
+		}
+	}
+	return false
+}
+
+// safeArchiveRelPath rejects a tar/zip entry name that would escape the
+// extraction root (zip-slip/tar-slip): absolute paths and any path whose
+// cleaned form starts with "..".
+func safeArchiveRelPath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("archive entry %q has an absolute path", name)
+	}
+	cleaned := filepath.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %q escapes the extraction root", name)
+	}
+	return cleaned, nil
+}
+
+func readTarEntries(archivePath string) ([]archiveEntry, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var entries []archiveEntry
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == manifestFilename {
+			continue
+		}
+		relPath, err := safeArchiveRelPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, archiveEntry{relPath: relPath, content: content})
+	}
+}
+
+func readZipEntries(archivePath string) ([]archiveEntry, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []archiveEntry
+	for _, f := range r.File {
+		if f.Name == manifestFilename {
+			continue
+		}
+		relPath, err := safeArchiveRelPath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, archiveEntry{relPath: relPath, content: content})
+	}
+	return entries, nil
+}
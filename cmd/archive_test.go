@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTarArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "schema.tar.gz")
+
+	writer, closeWriter, err := newArchiveWriter(outputTar, "", archivePath)
+	if err != nil {
+		t.Fatalf("newArchiveWriter() error = %v", err)
+	}
+	writeRoundTripFixture(t, writer)
+	if err := writer.Close("projects/p/instances/i/databases/d", "test-version"); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := closeWriter(); err != nil {
+		t.Fatalf("closeWriter() error = %v", err)
+	}
+
+	entries, err := readTarEntries(archivePath)
+	if err != nil {
+		t.Fatalf("readTarEntries() error = %v", err)
+	}
+	assertRoundTripFixture(t, entries)
+}
+
+func TestZipArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "schema.zip")
+
+	writer, closeWriter, err := newArchiveWriter(outputZip, "", archivePath)
+	if err != nil {
+		t.Fatalf("newArchiveWriter() error = %v", err)
+	}
+	writeRoundTripFixture(t, writer)
+	if err := writer.Close("projects/p/instances/i/databases/d", "test-version"); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := closeWriter(); err != nil {
+		t.Fatalf("closeWriter() error = %v", err)
+	}
+
+	entries, err := readZipEntries(archivePath)
+	if err != nil {
+		t.Fatalf("readZipEntries() error = %v", err)
+	}
+	assertRoundTripFixture(t, entries)
+}
+
+func writeRoundTripFixture(t *testing.T, writer archiveWriter) {
+	t.Helper()
+	if err := writer.WriteFile(filepath.Join(dirTable, "users.sql"), []byte("CREATE TABLE users (...)")); err != nil {
+		t.Fatalf("WriteFile(table) error = %v", err)
+	}
+	if err := writer.WriteFile(filepath.Join(dirStaticData, "roles.sql"), []byte("INSERT INTO roles ...")); err != nil {
+		t.Fatalf("WriteFile(static_data) error = %v", err)
+	}
+}
+
+func assertRoundTripFixture(t *testing.T, entries []archiveEntry) {
+	t.Helper()
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.relPath] = string(e.content)
+	}
+	want := map[string]string{
+		filepath.Join(dirTable, "users.sql"):      "CREATE TABLE users (...)",
+		filepath.Join(dirStaticData, "roles.sql"): "INSERT INTO roles ...",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestArchiveManifestContainsDigestsForEveryFile(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "schema.tar.gz")
+
+	writer, closeWriter, err := newArchiveWriter(outputTar, "", archivePath)
+	if err != nil {
+		t.Fatalf("newArchiveWriter() error = %v", err)
+	}
+	writeRoundTripFixture(t, writer)
+	if err := writer.Close("projects/p/instances/i/databases/d", "test-version"); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := closeWriter(); err != nil {
+		t.Fatalf("closeWriter() error = %v", err)
+	}
+
+	entries, err := readTarEntries(archivePath)
+	if err != nil {
+		t.Fatalf("readTarEntries() error = %v", err)
+	}
+
+	manifest := readManifestFromArchive(t, archivePath)
+	for _, e := range entries {
+		want := sha256Hex(e.content)
+		if got := manifest.Files[e.relPath]; got != want {
+			t.Errorf("manifest digest for %s = %q, want %q", e.relPath, got, want)
+		}
+	}
+	if manifest.Database != "projects/p/instances/i/databases/d" {
+		t.Errorf("manifest.Database = %q, want %q", manifest.Database, "projects/p/instances/i/databases/d")
+	}
+	if manifest.Version != "test-version" {
+		t.Errorf("manifest.Version = %q, want %q", manifest.Version, "test-version")
+	}
+}
+
+// readManifestFromArchive re-opens the tar.gz and decodes manifest.json,
+// bypassing readTarEntries (which deliberately skips it).
+func readManifestFromArchive(t *testing.T, archivePath string) archiveManifest {
+	t.Helper()
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			t.Fatalf("manifest.json not found in archive")
+		}
+		if err != nil {
+			t.Fatalf("tar.Next() error = %v", err)
+		}
+		if hdr.Name != manifestFilename {
+			continue
+		}
+		var m archiveManifest
+		if err := json.NewDecoder(tr).Decode(&m); err != nil {
+			t.Fatalf("decoding manifest: %v", err)
+		}
+		return m
+	}
+}
+
+func TestSafeArchiveRelPathRejectsTraversal(t *testing.T) {
+	cases := []string{
+		"../etc/passwd",
+		"table/../../etc/passwd",
+		"/etc/passwd",
+	}
+	for _, name := range cases {
+		if _, err := safeArchiveRelPath(name); err == nil {
+			t.Errorf("safeArchiveRelPath(%q) should have rejected a path escaping the extraction root", name)
+		}
+	}
+}
+
+func TestSafeArchiveRelPathAcceptsNormalEntries(t *testing.T) {
+	cases := map[string]string{
+		"table/users.sql":       "table/users.sql",
+		"static_data/roles.sql": "static_data/roles.sql",
+		"./table/users.sql":     "table/users.sql",
+	}
+	for name, want := range cases {
+		got, err := safeArchiveRelPath(name)
+		if err != nil {
+			t.Errorf("safeArchiveRelPath(%q) unexpected error: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("safeArchiveRelPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestClassifyArchiveEntriesSplitsByObjectType(t *testing.T) {
+	entries := []archiveEntry{
+		{relPath: filepath.Join(dirTable, "users.sql"), content: []byte("CREATE TABLE users (...)")},
+		{relPath: filepath.Join(dirIndex, "users_by_email.sql"), content: []byte("CREATE INDEX users_by_email ...")},
+		{relPath: filepath.Join(dirStaticData, "roles.sql"), content: []byte("INSERT INTO roles ...")},
+	}
+
+	ddlStatements, staticDataStatements, err := classifyArchiveEntries(entries)
+	if err != nil {
+		t.Fatalf("classifyArchiveEntries() error = %v", err)
+	}
+	wantDDL := []string{"CREATE TABLE users (...)", "CREATE INDEX users_by_email ..."}
+	if !reflect.DeepEqual(ddlStatements, wantDDL) {
+		t.Errorf("ddlStatements = %v, want %v", ddlStatements, wantDDL)
+	}
+	wantStaticData := []string{"INSERT INTO roles ..."}
+	if !reflect.DeepEqual(staticDataStatements, wantStaticData) {
+		t.Errorf("staticDataStatements = %v, want %v", staticDataStatements, wantStaticData)
+	}
+}
+
+func TestClassifyArchiveEntriesRejectsUnknownObjectType(t *testing.T) {
+	entries := []archiveEntry{
+		{relPath: "not_a_real_object_type/mystery.sql", content: []byte("DROP DATABASE prod")},
+	}
+	if _, _, err := classifyArchiveEntries(entries); err == nil {
+		t.Error("classifyArchiveEntries() should reject an entry outside table/index/static_data")
+	}
+}
+
+func TestFileModeForStaticDataVsDDL(t *testing.T) {
+	if got, want := fileModeFor(filepath.Join(dirStaticData, "roles.sql")), os.FileMode(0644); got != want {
+		t.Errorf("fileModeFor(static_data) = %v, want %v", got, want)
+	}
+	if got, want := fileModeFor(filepath.Join(dirTable, "users.sql")), os.FileMode(0664); got != want {
+		t.Errorf("fileModeFor(table) = %v, want %v", got, want)
+	}
+}
+
+func TestResolveOutputStdoutShorthand(t *testing.T) {
+	c := loadDiscreteCmd
+	if err := c.Flags().Set(flagNameArchivePath, ""); err != nil {
+		t.Fatalf("Set(%s) error = %v", flagNameArchivePath, err)
+	}
+
+	format, archivePath, err := resolveOutput(c, stdoutPath)
+	if err != nil {
+		t.Fatalf("resolveOutput() error = %v", err)
+	}
+	if format != outputTar {
+		t.Errorf("resolveOutput(%q) format = %q, want %q", stdoutPath, format, outputTar)
+	}
+	if archivePath != stdoutPath {
+		t.Errorf("resolveOutput(%q) archivePath = %q, want %q", stdoutPath, archivePath, stdoutPath)
+	}
+}
+
+func TestResolveOutputPassesThroughOtherFormats(t *testing.T) {
+	c := loadDiscreteCmd
+	if err := c.Flags().Set(flagNameArchivePath, "custom.zip"); err != nil {
+		t.Fatalf("Set(%s) error = %v", flagNameArchivePath, err)
+	}
+	defer c.Flags().Set(flagNameArchivePath, "")
+
+	format, archivePath, err := resolveOutput(c, outputZip)
+	if err != nil {
+		t.Fatalf("resolveOutput() error = %v", err)
+	}
+	if format != outputZip {
+		t.Errorf("resolveOutput(%q) format = %q, want %q", outputZip, format, outputZip)
+	}
+	if archivePath != "custom.zip" {
+		t.Errorf("resolveOutput(%q) archivePath = %q, want %q", outputZip, archivePath, "custom.zip")
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/roryq/wrench/pkg/errs"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagNameLogFormat = "log-format"
+
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String(flagNameLogFormat, logFormatText, "diagnostic log format for command failures: text or json")
+}
+
+// diagnosticRecord is the structured form of a command failure, emitted to
+// stderr one JSON object per line when --log-format=json is set.
+type diagnosticRecord struct {
+	Level      string `json:"level"`
+	Cmd        string `json:"cmd"`
+	ObjectType string `json:"object_type,omitempty"`
+	ObjectName string `json:"object_name,omitempty"`
+	Error      string `json:"error"`
+	Stack      string `json:"stack,omitempty"`
+}
+
+func isJSONLogFormat(c *cobra.Command) bool {
+	format, err := c.Flags().GetString(flagNameLogFormat)
+	return err == nil && format == logFormatJSON
+}
+
+// wrapErr annotates err with the object that was being processed via
+// errs.Wrapf and, when --log-format=json is active, additionally emits a
+// structured diagnosticRecord to stderr. It always returns the familiar
+// *Error (wrapping the annotated error) so callers upstream of cobra keep
+// treating this as an already-reported error regardless of log format.
+func wrapErr(c *cobra.Command, objectType, objectName string, err error, msgFormat string, args ...interface{}) error {
+	wrapped := errs.Wrapf(err, msgFormat, args...)
+
+	if isJSONLogFormat(c) {
+		record := diagnosticRecord{
+			Level:      "error",
+			Cmd:        c.Name(),
+			ObjectType: objectType,
+			ObjectName: objectName,
+			Error:      wrapped.Error(),
+			Stack:      errs.StackTrace(wrapped),
+		}
+		if b, marshalErr := json.Marshal(record); marshalErr == nil {
+			fmt.Fprintln(os.Stderr, string(b))
+		}
+	}
+
+	return &Error{
+		err: wrapped,
+		cmd: c,
+	}
+}
@@ -20,15 +20,14 @@
 package cmd
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 
+	"github.com/roryq/wrench/pkg/config"
 	"github.com/roryq/wrench/pkg/spanner"
 
 	"github.com/spf13/cobra"
@@ -40,10 +39,10 @@ const (
 	dirIndex      = "index"
 )
 
-type staticDataConfig struct {
-	StaticDataTables []string
-	CustomOrderBy    map[string]string
-}
+// staticDataConfig is kept as an alias so existing call sites (and tests)
+// that reference the cmd-local type keep compiling; the config itself now
+// lives in pkg/config so it can be shared with other loaders.
+type staticDataConfig = config.StaticDataConfig
 
 var loadCmd = &cobra.Command{
 	Use:   "load",
@@ -57,6 +56,24 @@ var loadDiscreteCmd = &cobra.Command{
 	RunE:  loadDiscrete,
 }
 
+var applyFromArchiveCmd = &cobra.Command{
+	Use:   "apply-from-archive <archive>",
+	Short: "Apply DDL and static data extracted from a load-discrete tar/zip archive",
+	Args:  cobra.ExactArgs(1),
+	RunE:  applyFromArchiveRun,
+}
+
+const (
+	flagNameOutput      = "output"
+	flagNameArchivePath = "archive-path"
+)
+
+func init() {
+	loadDiscreteCmd.Flags().String(flagNameOutput, outputFilesystem, "output format: fs, tar, zip, or - (stream a tar archive to stdout)")
+	loadDiscreteCmd.Flags().String(flagNameArchivePath, "", "destination file for --output=tar|zip (use \"-\" for stdout); defaults to schema.tar.gz / schema.zip")
+	rootCmd.AddCommand(applyFromArchiveCmd)
+}
+
 func load(c *cobra.Command, args []string) error {
 	ctx := context.Background()
 
@@ -68,18 +85,12 @@ func load(c *cobra.Command, args []string) error {
 
 	ddl, err := client.LoadDDL(ctx)
 	if err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
-		}
+		return wrapErr(c, "database", "", err, "loading DDL")
 	}
 
 	err = ioutil.WriteFile(schemaFilePath(c), ddl, 0664)
 	if err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
-		}
+		return wrapErr(c, "file", schemaFilePath(c), err, "writing schema file %s", schemaFilePath(c))
 	}
 
 	return nil
@@ -94,109 +105,146 @@ func loadDiscrete(c *cobra.Command, args []string) error {
 	}
 	defer client.Close()
 
+	format, err := c.Flags().GetString(flagNameOutput)
+	if err != nil {
+		return err
+	}
+
+	sdc, err := readStaticDataTablesFile(staticDataTablesFilePath(c))
+	if err != nil {
+		return wrapErr(c, "config", staticDataTablesFilePath(c), err, "reading static data config %s", staticDataTablesFilePath(c))
+	}
+
 	// load and write ddls
 	ddls, err := client.LoadDDLs(ctx)
 	if err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
-		}
+		return wrapErr(c, "database", "", err, "loading DDLs")
 	}
+	ddls = filterDDLs(ddls, sdc)
 
-	if err := clearSchemaDir(c); err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
+	if format == "" || format == outputFilesystem {
+		if err := clearSchemaDir(c); err != nil {
+			return wrapErr(c, "directory", schemaDirPath(c), err, "clearing schema directory %s", schemaDirPath(c))
 		}
 	}
-	for _, ddl := range ddls {
-		if err := writeDDL(ddl, schemaDirPath(c)); err != nil {
-			return &Error{
-				err: err,
-				cmd: c,
-			}
-		}
+
+	format, archivePath, err := resolveOutput(c, format)
+	if err != nil {
+		return err
 	}
 
-	// load and write static data
-	config, err := readStaticDataTablesFile(staticDataTablesFilePath(c))
+	writer, closeWriter, err := newArchiveWriter(format, schemaDirPath(c), archivePath)
 	if err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
+		return wrapErr(c, "output", format, err, "opening %s output", format)
+	}
+
+	for _, ddl := range ddls {
+		content := []byte(ddl.Statement)
+		if err := writer.WriteFile(filepath.Join(ddl.ObjectType, ddl.Filename), content); err != nil {
+			return wrapErr(c, ddl.ObjectType, ddl.Filename, err, "writing DDL for %s %s", ddl.ObjectType, ddl.Filename)
 		}
 	}
-	datas, err := client.LoadStaticDatas(ctx, config.StaticDataTables, config.CustomOrderBy)
+
+	// load and write static data: the allow-list passed to LoadStaticDatas is
+	// sdc.StaticDataTables narrowed to tables that survived filterDDLs above,
+	// so ExcludeTables/SchemaFilters apply consistently to both schema and data.
+	staticDataTables := staticDataAllowList(ddls, sdc.StaticDataTables)
+	datas, err := client.LoadStaticDatas(ctx, staticDataTables, sdc.CustomOrderBy)
 	if err != nil {
-		return &Error{
-			err: err,
-			cmd: c,
-		}
+		return wrapErr(c, "table", strings.Join(staticDataTables, ","), err, "loading static data for tables %q", staticDataTables)
 	}
 	for _, d := range datas {
-		if err := writeData(d, schemaDirPath(c)); err != nil {
-			return &Error{
-				err: err,
-				cmd: c,
-			}
+		content := []byte(strings.Join(d.Statements, "\n"))
+		if err := writer.WriteFile(filepath.Join(dirStaticData, d.ToFileName()), content); err != nil {
+			return wrapErr(c, dirStaticData, d.ToFileName(), err, "writing static data for table %q", d.ToFileName())
 		}
 	}
 
-	return nil
+	if err := writer.Close(databaseName(c), buildVersion); err != nil {
+		return wrapErr(c, "output", format, err, "finalizing %s output", format)
+	}
+
+	return closeWriter()
 }
 
-func readStaticDataTablesFile(filePath string) (sdc staticDataConfig, err error) {
-	filePath = path.Clean(filePath)
-	if strings.HasSuffix(filePath, defaultStaticDataTablesFile) {
-		// try both structured config or text file
-		jsonPath := strings.ReplaceAll(filePath, defaultStaticDataTablesFile, "wrench.json")
-		sdc, err = readJsonFile(jsonPath)
-		if err == nil {
-			return sdc, nil
-		}
-		txtPath := strings.ReplaceAll(filePath, defaultStaticDataTablesFile, "static_data_tables.txt")
-		sdc.StaticDataTables, err = readTxtFile(txtPath)
-	} else if strings.HasSuffix(filePath, ".json") {
-		sdc, err = readJsonFile(filePath)
-	} else if strings.HasSuffix(filePath, ".txt") {
-		sdc.StaticDataTables, err = readTxtFile(filePath)
+func applyFromArchiveRun(c *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	client, err := newSpannerClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := applyFromArchive(ctx, client, args[0]); err != nil {
+		return wrapErr(c, "archive", args[0], err, "applying archive %s", args[0])
 	}
 
-	return sdc, err
+	return nil
 }
 
-func openFile(p string) (*os.File, error, func()) {
-	f, err := os.Open(p)
-	if os.IsNotExist(err) {
-		return nil, nil, func() {}
+// ddlObjectName returns the DDL object's name as matched against
+// ExcludeTables/SchemaFilters and static data table lists: its filename with
+// the extension stripped.
+func ddlObjectName(ddl spanner.SchemaDDL) string {
+	return strings.TrimSuffix(ddl.Filename, filepath.Ext(ddl.Filename))
+}
+
+// filterDDLs keeps only the DDL objects that pass sdc.ExcludeTables (matched
+// against table objects only, per the request's "globs applied against
+// LoadDDLs output") and sdc.SchemaFilters (matched per object type). It is a
+// no-op when neither is configured.
+func filterDDLs(ddls []spanner.SchemaDDL, sdc staticDataConfig) []spanner.SchemaDDL {
+	if len(sdc.ExcludeTables) == 0 && len(sdc.SchemaFilters) == 0 {
+		return ddls
 	}
-	if err != nil {
-		return nil, err, func() {}
+	filtered := make([]spanner.SchemaDDL, 0, len(ddls))
+	for _, ddl := range ddls {
+		name := ddlObjectName(ddl)
+		if ddl.ObjectType == dirTable && config.MatchesAny(sdc.ExcludeTables, name) {
+			continue
+		}
+		if !sdc.SchemaFilters.Matches(ddl.ObjectType, name) {
+			continue
+		}
+		filtered = append(filtered, ddl)
 	}
-	return f, err, func() { f.Close() }
+	return filtered
 }
 
-func readJsonFile(filePath string) (staticDataConfig, error) {
-	f, err, done := openFile(filePath)
-	defer done()
-	bytes, err := ioutil.ReadAll(f)
-	var d staticDataConfig
-	err = json.Unmarshal(bytes, &d)
-	return d, err
+// staticDataAllowList narrows configuredTables to the names of table DDL
+// objects that survived filterDDLs, so a table dropped by ExcludeTables or
+// SchemaFilters is never loaded as static data either.
+func staticDataAllowList(ddls []spanner.SchemaDDL, configuredTables []string) []string {
+	if len(configuredTables) == 0 {
+		return configuredTables
+	}
+	tableNames := make(map[string]bool, len(ddls))
+	for _, ddl := range ddls {
+		if ddl.ObjectType == dirTable {
+			tableNames[ddlObjectName(ddl)] = true
+		}
+	}
+	allow := make([]string, 0, len(configuredTables))
+	for _, t := range configuredTables {
+		if tableNames[t] {
+			allow = append(allow, t)
+		}
+	}
+	return allow
 }
 
-func readTxtFile(filePath string) ([]string, error) {
-	f, err, done := openFile(filePath)
-	if err != nil {
-		return []string{}, err
-	}
-	defer done()
-	scanner := bufio.NewScanner(f)
-	tables := []string{}
-	for scanner.Scan() {
-		tables = append(tables, scanner.Text())
+// readStaticDataTablesFile resolves the static-data/schema-filter config.
+// When filePath is the default static_data_tables.txt location it is treated
+// as a directory to probe (wrench.yaml, wrench.yml, wrench.json, then the
+// legacy text file); an explicit path is loaded directly by extension.
+func readStaticDataTablesFile(filePath string) (staticDataConfig, error) {
+	filePath = path.Clean(filePath)
+	if strings.HasSuffix(filePath, defaultStaticDataTablesFile) {
+		dir := strings.TrimSuffix(filePath, defaultStaticDataTablesFile)
+		return config.NewLoader(dir).Load()
 	}
-	return tables, nil
+	return config.LoadFile(filePath)
 }
 
 func writeDDL(ddl spanner.SchemaDDL, schemaDir string) error {
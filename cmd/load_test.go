@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/roryq/wrench/pkg/config"
+	"github.com/roryq/wrench/pkg/spanner"
+)
+
+func ddl(objectType, name string) spanner.SchemaDDL {
+	return spanner.SchemaDDL{ObjectType: objectType, Filename: name + ".sql"}
+}
+
+func TestFilterDDLsExcludeTablesOnlyAppliesToTables(t *testing.T) {
+	ddls := []spanner.SchemaDDL{
+		ddl(dirTable, "users"),
+		ddl(dirTable, "tmp_cache"),
+		ddl(dirIndex, "tmp_cache_idx"),
+	}
+	sdc := staticDataConfig{ExcludeTables: []string{"tmp_*"}}
+
+	got := filterDDLs(ddls, sdc)
+
+	want := []spanner.SchemaDDL{ddl(dirTable, "users"), ddl(dirIndex, "tmp_cache_idx")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterDDLs() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterDDLsSchemaFiltersPerObjectType(t *testing.T) {
+	ddls := []spanner.SchemaDDL{
+		ddl(dirTable, "users"),
+		ddl(dirIndex, "users_by_email"),
+		ddl(dirIndex, "users_internal"),
+	}
+	sdc := staticDataConfig{
+		SchemaFilters: config.SchemaFilters{
+			dirIndex: {Exclude: []string{"*_internal"}},
+		},
+	}
+
+	got := filterDDLs(ddls, sdc)
+
+	want := []spanner.SchemaDDL{ddl(dirTable, "users"), ddl(dirIndex, "users_by_email")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterDDLs() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterDDLsNoopWhenUnconfigured(t *testing.T) {
+	ddls := []spanner.SchemaDDL{ddl(dirTable, "users")}
+	got := filterDDLs(ddls, staticDataConfig{})
+	if !reflect.DeepEqual(got, ddls) {
+		t.Errorf("filterDDLs() = %v, want %v unchanged", got, ddls)
+	}
+}
+
+func TestStaticDataAllowListDropsTablesExcludedFromDDL(t *testing.T) {
+	ddls := []spanner.SchemaDDL{ddl(dirTable, "users"), ddl(dirTable, "roles")}
+
+	got := staticDataAllowList(ddls, []string{"users", "roles", "archived_orders"})
+
+	want := []string{"users", "roles"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("staticDataAllowList() = %v, want %v", got, want)
+	}
+}
+
+func TestStaticDataAllowListEmptyConfiguredTables(t *testing.T) {
+	ddls := []spanner.SchemaDDL{ddl(dirTable, "users")}
+	if got := staticDataAllowList(ddls, nil); len(got) != 0 {
+		t.Errorf("staticDataAllowList() = %v, want empty", got)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/roryq/wrench/pkg/spanner"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagNameInterval  = "interval"
+	flagNameDebounce  = "debounce"
+	flagNameOnChange  = "on-change"
+	defaultInterval   = 10 * time.Second
+	defaultDebounce   = 2 * time.Second
+	watchBackoffStart = time.Second
+	watchBackoffMax   = time.Minute
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Continuously mirror schema and static data to discrete files on disk",
+	RunE:  watch,
+}
+
+func init() {
+	watchCmd.Flags().Duration(flagNameInterval, defaultInterval, "polling interval")
+	watchCmd.Flags().Duration(flagNameDebounce, defaultDebounce, "coalesce changes seen within this window before running --on-change (files are still written on every poll)")
+	watchCmd.Flags().String(flagNameOnChange, "", "shell command to run after a diff is applied to disk")
+	rootCmd.AddCommand(watchCmd)
+}
+
+// fileDigests maps a path relative to the schema directory to the SHA256 of its last-written content.
+type fileDigests map[string]string
+
+// fileStatus is the outcome of comparing a freshly loaded object's content
+// against fileDigests.
+type fileStatus int
+
+const (
+	fileUnchanged fileStatus = iota
+	fileAdded
+	fileModified
+)
+
+// pollSummary tallies the outcome of a single pollOnce call.
+type pollSummary struct {
+	Added, Modified, Deleted int
+}
+
+func (s pollSummary) Total() int {
+	return s.Added + s.Modified + s.Deleted
+}
+
+func (s *pollSummary) add(other pollSummary) {
+	s.Added += other.Added
+	s.Modified += other.Modified
+	s.Deleted += other.Deleted
+}
+
+func watch(c *cobra.Command, args []string) error {
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	interval, err := c.Flags().GetDuration(flagNameInterval)
+	if err != nil {
+		return err
+	}
+	debounce, err := c.Flags().GetDuration(flagNameDebounce)
+	if err != nil {
+		return err
+	}
+	onChange, err := c.Flags().GetString(flagNameOnChange)
+	if err != nil {
+		return err
+	}
+
+	client, err := newSpannerClient(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	digests := fileDigests{}
+	backoff := watchBackoffStart
+	var pending pollSummary
+	lastApplied := time.Now()
+
+	for {
+		summary, err := pollOnce(ctx, client, c, digests)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("watch: poll failed, retrying in %s: %v", backoff, err)
+			if err := sleepOrDone(ctx, backoff); err != nil {
+				return err
+			}
+			backoff *= 2
+			if backoff > watchBackoffMax {
+				backoff = watchBackoffMax
+			}
+			continue
+		}
+		backoff = watchBackoffStart
+
+		if summary.Total() > 0 {
+			log.Printf("watch: poll: %d added, %d modified, %d deleted", summary.Added, summary.Modified, summary.Deleted)
+			pending.add(summary)
+			lastApplied = time.Now()
+		}
+		if pending.Total() > 0 && time.Since(lastApplied) >= debounce {
+			log.Printf("watch: applied %d added, %d modified, %d deleted", pending.Added, pending.Modified, pending.Deleted)
+			if onChange != "" {
+				if err := runOnChange(onChange); err != nil {
+					log.Printf("watch: on-change command failed: %v", err)
+				}
+			}
+			pending = pollSummary{}
+		}
+
+		if err := sleepOrDone(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepOrDone waits for d to elapse, returning early with ctx.Err() if ctx is
+// canceled first.
+func sleepOrDone(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pollOnce loads the current DDLs and static data, rewrites any files whose content changed
+// against digests, removes files for objects that no longer exist, and returns a summary of
+// how many objects were added, modified, and deleted.
+func pollOnce(ctx context.Context, client *spanner.Client, c *cobra.Command, digests fileDigests) (pollSummary, error) {
+	var summary pollSummary
+
+	ddls, err := client.LoadDDLs(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	config, err := readStaticDataTablesFile(staticDataTablesFilePath(c))
+	if err != nil {
+		return summary, err
+	}
+	// ExcludeTables/SchemaFilters apply the same way here as in loadDiscrete,
+	// so a table load-discrete drops from the schema isn't still mirrored by
+	// watch.
+	ddls = filterDDLs(ddls, config)
+	staticDataTables := staticDataAllowList(ddls, config.StaticDataTables)
+	datas, err := client.LoadStaticDatas(ctx, staticDataTables, config.CustomOrderBy)
+	if err != nil {
+		return summary, err
+	}
+
+	seen := map[string]bool{}
+
+	for _, ddl := range ddls {
+		rel := filepath.Join(ddl.ObjectType, ddl.Filename)
+		seen[rel] = true
+		status := applyIfChanged(rel, []byte(ddl.Statement), digests)
+		if status == fileUnchanged {
+			continue
+		}
+		if err := writeDDL(ddl, schemaDirPath(c)); err != nil {
+			return summary, err
+		}
+		summary.record(status)
+	}
+
+	for _, d := range datas {
+		rel := filepath.Join(dirStaticData, d.ToFileName())
+		seen[rel] = true
+		content := []byte(strings.Join(d.Statements, "\n"))
+		status := applyIfChanged(rel, content, digests)
+		if status == fileUnchanged {
+			continue
+		}
+		if err := writeData(d, schemaDirPath(c)); err != nil {
+			return summary, err
+		}
+		summary.record(status)
+	}
+
+	for rel := range digests {
+		if seen[rel] {
+			continue
+		}
+		delete(digests, rel)
+		if err := os.Remove(filepath.Join(schemaDirPath(c), rel)); err != nil && !os.IsNotExist(err) {
+			return summary, err
+		}
+		summary.Deleted++
+	}
+
+	return summary, nil
+}
+
+func (s *pollSummary) record(status fileStatus) {
+	switch status {
+	case fileAdded:
+		s.Added++
+	case fileModified:
+		s.Modified++
+	}
+}
+
+// applyIfChanged reports how content's digest compares to the one recorded for rel,
+// updating digests to the new value as a side effect.
+func applyIfChanged(rel string, content []byte, digests fileDigests) fileStatus {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	prev, existed := digests[rel]
+	digests[rel] = hash
+	if !existed {
+		return fileAdded
+	}
+	if prev == hash {
+		return fileUnchanged
+	}
+	return fileModified
+}
+
+func runOnChange(cmdline string) error {
+	out, err := exec.Command("sh", "-c", cmdline).CombinedOutput()
+	if len(out) > 0 {
+		log.Print(string(out))
+	}
+	return err
+}
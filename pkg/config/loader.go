@@ -0,0 +1,164 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package config resolves the optional static-data/schema-filter file that sits
+// alongside a wrench migrations directory, accepting either YAML, JSON, or the
+// legacy newline-delimited text format.
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticDataConfig configures which tables wrench treats as static data, and
+// optionally how `load-discrete` filters the schema it checks out.
+type StaticDataConfig struct {
+	StaticDataTables []string          `json:"StaticDataTables" yaml:"staticDataTables"`
+	CustomOrderBy    map[string]string `json:"CustomOrderBy" yaml:"customOrderBy"`
+
+	// ExcludeTables is a set of glob patterns matched against table DDL object
+	// names (i.e. load-discrete's LoadDDLs output); matching tables are
+	// dropped before any DDL is written, and therefore also from the static data
+	// allow-list derived from the surviving tables.
+	ExcludeTables []string `json:"ExcludeTables" yaml:"excludeTables"`
+
+	// SchemaFilters narrows which DDL objects load-discrete writes out,
+	// keyed by object type ("table", "index", ...).
+	SchemaFilters SchemaFilters `json:"SchemaFilters" yaml:"schemaFilters"`
+}
+
+// ObjectFilter is an include/exclude glob list for one DDL object type. An
+// object is kept if it matches Include (or Include is empty) and does not
+// match Exclude.
+type ObjectFilter struct {
+	Include []string `json:"Include" yaml:"include"`
+	Exclude []string `json:"Exclude" yaml:"exclude"`
+}
+
+// SchemaFilters maps a DDL object type to its ObjectFilter. An object type
+// absent from the map is left unfiltered.
+type SchemaFilters map[string]ObjectFilter
+
+// Matches reports whether name (e.g. a DDL filename without extension)
+// should be kept under the filter configured for objectType.
+func (f SchemaFilters) Matches(objectType, name string) bool {
+	filter, ok := f[objectType]
+	if !ok {
+		return true
+	}
+	if len(filter.Include) > 0 && !MatchesAny(filter.Include, name) {
+		return false
+	}
+	return !MatchesAny(filter.Exclude, name)
+}
+
+// MatchesAny reports whether name matches any of the given glob patterns.
+func MatchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateFiles are probed in order, relative to a Loader's directory.
+var candidateFiles = []string{"wrench.yaml", "wrench.yml", "wrench.json", "static_data_tables.txt"}
+
+// Loader resolves a StaticDataConfig from a directory, trying each of
+// candidateFiles in turn.
+type Loader struct {
+	Dir string
+}
+
+// NewLoader returns a Loader rooted at dir.
+func NewLoader(dir string) *Loader {
+	return &Loader{Dir: dir}
+}
+
+// Load probes wrench.yaml, wrench.yml, wrench.json, then the legacy
+// static_data_tables.txt, returning the config from the first one found.
+// A missing directory entry for every candidate is not an error; it yields
+// a zero-value StaticDataConfig.
+func (l *Loader) Load() (StaticDataConfig, error) {
+	for _, name := range candidateFiles {
+		p := filepath.Join(l.Dir, name)
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if name == "static_data_tables.txt" {
+			tables, err := readTxtFile(p)
+			return StaticDataConfig{StaticDataTables: tables}, err
+		}
+		return readStructuredFile(p)
+	}
+	return StaticDataConfig{}, nil
+}
+
+func readStructuredFile(filePath string) (StaticDataConfig, error) {
+	var sdc StaticDataConfig
+	bytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return sdc, err
+	}
+	if strings.HasSuffix(filePath, ".json") {
+		err = json.Unmarshal(bytes, &sdc)
+	} else {
+		err = yaml.Unmarshal(bytes, &sdc)
+	}
+	return sdc, err
+}
+
+// LoadFile reads a single config file directly, dispatching on its extension.
+// It is used when the caller has an explicit path rather than a directory to
+// probe (see Loader.Load).
+func LoadFile(filePath string) (StaticDataConfig, error) {
+	switch {
+	case strings.HasSuffix(filePath, ".json"), strings.HasSuffix(filePath, ".yaml"), strings.HasSuffix(filePath, ".yml"):
+		return readStructuredFile(filePath)
+	case strings.HasSuffix(filePath, ".txt"):
+		tables, err := readTxtFile(filePath)
+		return StaticDataConfig{StaticDataTables: tables}, err
+	default:
+		return StaticDataConfig{}, fmt.Errorf("unsupported config file %q", filePath)
+	}
+}
+
+func readTxtFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	tables := []string{}
+	for scanner.Scan() {
+		tables = append(tables, scanner.Text())
+	}
+	return tables, scanner.Err()
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+}
+
+func TestLoaderProbeOrder(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  StaticDataConfig
+	}{
+		{
+			name: "wrench.yaml wins over everything",
+			files: map[string]string{
+				"wrench.yaml":            "staticDataTables:\n  - from_yaml\n",
+				"wrench.yml":             "staticDataTables:\n  - from_yml\n",
+				"wrench.json":            `{"StaticDataTables":["from_json"]}`,
+				"static_data_tables.txt": "from_txt\n",
+			},
+			want: StaticDataConfig{StaticDataTables: []string{"from_yaml"}},
+		},
+		{
+			name: "wrench.yml wins over json and txt",
+			files: map[string]string{
+				"wrench.yml":             "staticDataTables:\n  - from_yml\n",
+				"wrench.json":            `{"StaticDataTables":["from_json"]}`,
+				"static_data_tables.txt": "from_txt\n",
+			},
+			want: StaticDataConfig{StaticDataTables: []string{"from_yml"}},
+		},
+		{
+			name: "wrench.json wins over legacy txt",
+			files: map[string]string{
+				"wrench.json":            `{"StaticDataTables":["from_json"]}`,
+				"static_data_tables.txt": "from_txt\n",
+			},
+			want: StaticDataConfig{StaticDataTables: []string{"from_json"}},
+		},
+		{
+			name: "falls back to legacy txt",
+			files: map[string]string{
+				"static_data_tables.txt": "users\nroles\n",
+			},
+			want: StaticDataConfig{StaticDataTables: []string{"users", "roles"}},
+		},
+		{
+			name:  "no candidate files yields zero value",
+			files: map[string]string{},
+			want:  StaticDataConfig{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tc.files {
+				writeFile(t, dir, name, content)
+			}
+			got, err := NewLoader(dir).Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.StaticDataTables, tc.want.StaticDataTables) {
+				t.Errorf("StaticDataTables = %v, want %v", got.StaticDataTables, tc.want.StaticDataTables)
+			}
+		})
+	}
+}
+
+func TestLoaderYAMLExtendedFields(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "wrench.yaml", `
+staticDataTables:
+  - roles
+customOrderBy:
+  roles: name ASC
+excludeTables:
+  - tmp_*
+schemaFilters:
+  index:
+    exclude:
+      - "*_internal"
+`)
+
+	got, err := NewLoader(dir).Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if want := []string{"roles"}; !reflect.DeepEqual(got.StaticDataTables, want) {
+		t.Errorf("StaticDataTables = %v, want %v", got.StaticDataTables, want)
+	}
+	if want := "name ASC"; got.CustomOrderBy["roles"] != want {
+		t.Errorf("CustomOrderBy[roles] = %q, want %q", got.CustomOrderBy["roles"], want)
+	}
+	if want := []string{"tmp_*"}; !reflect.DeepEqual(got.ExcludeTables, want) {
+		t.Errorf("ExcludeTables = %v, want %v", got.ExcludeTables, want)
+	}
+	if !got.SchemaFilters.Matches("table", "anything") {
+		t.Error("table objects should be unaffected by an index-only filter")
+	}
+	if got.SchemaFilters.Matches("index", "accounts_internal") {
+		t.Error("index matching the exclude glob should be filtered out")
+	}
+	if !got.SchemaFilters.Matches("index", "accounts_by_email") {
+		t.Error("index not matching the exclude glob should be kept")
+	}
+}
+
+func TestSchemaFiltersMatches(t *testing.T) {
+	filters := SchemaFilters{
+		"table": {
+			Include: []string{"public_*"},
+		},
+	}
+
+	if filters.Matches("table", "internal_cache") {
+		t.Error("table not matching Include should be dropped")
+	}
+	if !filters.Matches("table", "public_users") {
+		t.Error("table matching Include should be kept")
+	}
+	if !filters.Matches("index", "anything") {
+		t.Error("object type with no configured filter should be kept")
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	patterns := []string{"tmp_*", "scratch"}
+	if !MatchesAny(patterns, "tmp_orders") {
+		t.Error("expected tmp_orders to match tmp_*")
+	}
+	if !MatchesAny(patterns, "scratch") {
+		t.Error("expected exact match on scratch")
+	}
+	if MatchesAny(patterns, "orders") {
+		t.Error("expected orders not to match any pattern")
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package errs wraps github.com/pkg/errors so call sites can attach the
+// specific object (a DDL file, a table name, ...) that was being processed
+// when an operation failed, while keeping a stack trace for diagnostics.
+package errs
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Wrapf annotates err with a message built from format/args and records a
+// stack trace at the call site. It returns nil if err is nil.
+func Wrapf(err error, format string, args ...interface{}) error {
+	return errors.Wrapf(err, format, args...)
+}
+
+// StackTrace returns a formatted stack trace for err if one was recorded by
+// Wrapf, or "" otherwise.
+func StackTrace(err error) string {
+	type stackTracer interface {
+		StackTrace() errors.StackTrace
+	}
+	st, ok := err.(stackTracer)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%+v", st.StackTrace())
+}
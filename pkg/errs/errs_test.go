@@ -0,0 +1,53 @@
+// Copyright (c) 2020 Mercari, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of
+// this software and associated documentation files (the "Software"), to deal in
+// the Software without restriction, including without limitation the rights to
+// use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of
+// the Software, and to permit persons to whom the Software is furnished to do so,
+// subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS
+// FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR
+// COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER
+// IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN
+// CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package errs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWrapfNilIsNil(t *testing.T) {
+	if err := Wrapf(nil, "loading %s", "foo"); err != nil {
+		t.Errorf("Wrapf(nil, ...) = %v, want nil", err)
+	}
+}
+
+func TestWrapfAnnotatesMessage(t *testing.T) {
+	err := Wrapf(errors.New("boom"), "loading %s", "foo")
+	if err == nil {
+		t.Fatal("Wrapf() = nil, want an error")
+	}
+	if !strings.Contains(err.Error(), "loading foo") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Wrapf() error = %q, want it to contain both the message and the cause", err.Error())
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	if st := StackTrace(errors.New("no stack recorded")); st != "" {
+		t.Errorf("StackTrace() on a plain error = %q, want \"\"", st)
+	}
+
+	wrapped := Wrapf(errors.New("boom"), "loading %s", "foo")
+	if st := StackTrace(wrapped); st == "" {
+		t.Error("StackTrace() on a Wrapf-ed error = \"\", want a non-empty trace")
+	}
+}